@@ -5,7 +5,8 @@ import (
 	"os"
 	"path/filepath"
 
-	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
 )
 
 const (
@@ -20,13 +21,41 @@ var (
 )
 
 type Config struct {
-	Image string `yaml:"image"`
+	Image string `json:"image"`
 
-	PauseCommand []string `yaml:"pauseCommand"`
-	ShellCommand []string `yaml:"shellCommand"`
+	PauseCommand []string `json:"pauseCommand"`
+	ShellCommand []string `json:"shellCommand"`
 
-	PodNamespace string `yaml:"podNamespace"`
-	PodName      string `yaml:"podName"`
+	PodNamespace string `json:"podNamespace"`
+	PodName      string `json:"podName"`
+
+	// Recording, when set, is the default asciicast v2 file path Run()
+	// records interactive sessions to. The --record flag overrides it.
+	Recording string `json:"recording,omitempty"`
+
+	// PodTemplate allows overriding the node-shell pod's spec for clusters
+	// that require tolerations, a custom node selector, private registry
+	// credentials, or other restrictions the default privileged/host-namespace
+	// spec doesn't satisfy on its own.
+	PodTemplate PodTemplate `json:"podTemplate"`
+}
+
+// PodTemplate holds the subset of a pod spec users are allowed to customize.
+// It's merged onto the base node-shell container in NodeShell.buildPod().
+type PodTemplate struct {
+	Tolerations      []v1.Toleration           `json:"tolerations,omitempty"`
+	NodeSelector     map[string]string         `json:"nodeSelector,omitempty"`
+	ImagePullSecrets []v1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	HostAliases      []v1.HostAlias            `json:"hostAliases,omitempty"`
+
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	PriorityClassName  string `json:"priorityClassName,omitempty"`
+
+	Resources v1.ResourceRequirements `json:"resources,omitempty"`
+	Env       []v1.EnvVar             `json:"env,omitempty"`
+
+	ExtraVolumes      []v1.Volume      `json:"extraVolumes,omitempty"`
+	ExtraVolumeMounts []v1.VolumeMount `json:"extraVolumeMounts,omitempty"`
 }
 
 func Load(configPath string) (*Config, error) {
@@ -5,17 +5,24 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/fioncat/kubesh/config"
 	"github.com/fioncat/kubesh/nodeshell"
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/yaml"
 )
 
+const inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
 var (
 	Version   string = "N/A"
 	Commit    string = "N/A"
@@ -27,11 +34,28 @@ func main() {
 
 	err := cmd.Execute()
 	if err != nil {
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// exitCodeError carries a process exit code out of a RunE function so main()
+// can call os.Exit after RunE returns, once every deferred cleanup (e.g.
+// node-shell pod teardown) in that call stack has already run. Calling
+// os.Exit directly from a function with its own defers would skip them.
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("exit code %d", e.code)
+}
+
 func newCommand() *cobra.Command {
 	var buildInfo bool
 	var opts commandOptions
@@ -75,18 +99,153 @@ func newCommand() *cobra.Command {
 	cmd.Flags().BoolVarP(&opts.keepPod, "keep", "k", false, "don't delete shell pod after exit")
 	cmd.Flags().BoolVarP(&opts.killPod, "kill", "K", false, "kill shell pod")
 	cmd.Flags().BoolVarP(&opts.Insecure, "insecure", "i", false, "allow insecure connection to cluster")
+	cmd.Flags().StringVar(&opts.podTemplateFile, "pod-template-file", "", "path to a raw v1.Pod YAML overlay applied on top of the generated node-shell pod")
+	cmd.Flags().StringVar(&opts.recordPath, "record", "", "record the interactive session to an asciicast v2 file")
+	addFanoutFlags(cmd, &opts)
+	addKubeConfigFlags(cmd, &opts)
+
+	cmd.AddCommand(newExecCommand())
+	cmd.AddCommand(newCpCommand())
+	cmd.AddCommand(newForwardCommand())
 
 	return cmd
 }
 
+// addClusterFlags registers the flags shared by every subcommand that needs
+// to talk to the cluster and manage a node-shell pod.
+func addClusterFlags(cmd *cobra.Command, opts *commandOptions) {
+	cmd.Flags().StringVarP(&opts.configPath, "config", "c", "", "kubesh config file path (default ~/.config/kubesh.yaml)")
+	cmd.Flags().StringVar(&opts.kubeConfigPath, "kubeconfig", "", "kubeconfig file path (default from env $KUBECONFIG and ~/.kube/config)")
+
+	cmd.Flags().BoolVarP(&opts.keepPod, "keep", "k", false, "don't delete shell pod after exit")
+	cmd.Flags().BoolVarP(&opts.Insecure, "insecure", "i", false, "allow insecure connection to cluster")
+	cmd.Flags().StringVar(&opts.podTemplateFile, "pod-template-file", "", "path to a raw v1.Pod YAML overlay applied on top of the generated node-shell pod")
+	addKubeConfigFlags(cmd, &opts)
+}
+
+// addKubeConfigFlags registers the kubeconfig context/namespace override
+// flags every command needs to pick the right cluster.
+func addKubeConfigFlags(cmd *cobra.Command, opts *commandOptions) {
+	cmd.Flags().StringVar(&opts.kubeContext, "context", "", "kubeconfig context to use (default from current context)")
+	cmd.Flags().StringVar(&opts.kubeCluster, "cluster", "", "kubeconfig cluster to use (default from current context)")
+	cmd.Flags().StringVar(&opts.kubeUser, "user", "", "kubeconfig user to use (default from current context)")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "", "namespace to create the node-shell pod in (default from config podNamespace)")
+	cmd.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "print the resolved context and namespace before creating a node-shell pod")
+}
+
+// addFanoutFlags registers the node-selection and concurrency flags shared by
+// commands that can target more than one node at once.
+func addFanoutFlags(cmd *cobra.Command, opts *commandOptions) {
+	cmd.Flags().BoolVar(&opts.allNodes, "all", false, "run on every node in the cluster")
+	cmd.Flags().StringVarP(&opts.selector, "selector", "l", "", "run on nodes matching this label selector")
+	cmd.Flags().StringArrayVar(&opts.nodeNames, "node", nil, "run on this node, can be repeated")
+	cmd.Flags().IntVar(&opts.parallel, "parallel", 0, "max number of nodes to run on concurrently (default: all matched nodes)")
+}
+
+func newExecCommand() *cobra.Command {
+	var opts commandOptions
+
+	cmd := &cobra.Command{
+		Use:   "exec [<node>] -- <cmd...>",
+		Short: "Run a non-interactive command on a node without logging in",
+		Long:  "Run a non-interactive command on a node without logging in. Target multiple nodes at once with --all, --selector, or repeated --node instead of a positional <node>.",
+
+		Args: cobra.MinimumNArgs(1),
+
+		SilenceErrors: true,
+		SilenceUsage:  true,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dash := cmd.ArgsLenAtDash()
+			if dash < 0 || dash > 1 {
+				return errors.New("usage: kubesh exec [<node>] -- <cmd...>")
+			}
+			if dash == 1 {
+				opts.nodeName = args[0]
+			}
+
+			command := args[dash:]
+			if len(command) == 0 {
+				return errors.New("missing command to execute")
+			}
+
+			return opts.runExec(command)
+		},
+	}
+
+	addClusterFlags(cmd, &opts)
+	addFanoutFlags(cmd, &opts)
+	return cmd
+}
+
+func newCpCommand() *cobra.Command {
+	var opts commandOptions
+
+	cmd := &cobra.Command{
+		Use:   "cp <src> <dst>",
+		Short: "Copy files between a node and the local machine",
+		Long:  "Copy files between a node and the local machine, using <node>:<path> to refer to the remote side, e.g. kubesh cp node1:/var/log/syslog ./syslog",
+
+		Args: cobra.ExactArgs(2),
+
+		SilenceErrors: true,
+		SilenceUsage:  true,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.runCp(args[0], args[1])
+		},
+	}
+
+	addClusterFlags(cmd, &opts)
+	return cmd
+}
+
+func newForwardCommand() *cobra.Command {
+	var opts commandOptions
+
+	cmd := &cobra.Command{
+		Use:   "forward <node> <hostPort:targetHost:targetPort>...",
+		Short: "Forward local ports to node-reachable addresses through the node-shell pod",
+		Long:  "Forward local ports to addresses reachable from the node's network namespace (kubelet, container runtime, etc.) through the node-shell pod, e.g. kubesh forward node1 8080:localhost:80 5432:10.0.0.5:5432",
+
+		Args: cobra.MinimumNArgs(2),
+
+		SilenceErrors: true,
+		SilenceUsage:  true,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.nodeName = args[0]
+			return opts.runForward(args[1:])
+		},
+	}
+
+	addClusterFlags(cmd, &opts)
+	return cmd
+}
+
 type commandOptions struct {
 	nodeName string
 
 	keepPod bool
 	killPod bool
 
-	configPath     string
-	kubeConfigPath string
+	configPath      string
+	kubeConfigPath  string
+	podTemplateFile string
+	recordPath      string
+
+	allNodes  bool
+	selector  string
+	nodeNames []string
+	parallel  int
+
+	kubeContext string
+	kubeCluster string
+	kubeUser    string
+	namespace   string // explicit --namespace override, wins over config.PodNamespace
+	verbose     bool
+
+	resolvedNamespace string // namespace, or the kubeconfig context's default when namespace is unset
 
 	Insecure bool
 
@@ -100,17 +259,20 @@ func (o *commandOptions) run() error {
 		return err
 	}
 
-	err = o.ensureNode()
+	nodes, err := o.resolveNodes()
 	if err != nil {
 		return err
 	}
+	if len(nodes) > 0 {
+		return o.runFanoutShell(nodes)
+	}
 
-	config, err := config.Load(o.configPath)
+	err = o.ensureNode()
 	if err != nil {
 		return err
 	}
 
-	nodeShell, err := nodeshell.New(o.nodeName, config, o.kubeConfig, o.kubeClient)
+	nodeShell, err := o.newNodeShell(o.nodeName)
 	if err != nil {
 		return err
 	}
@@ -124,6 +286,350 @@ func (o *commandOptions) run() error {
 	return nodeShell.Run()
 }
 
+// runFanoutShell opens a node shell on every node and broadcasts stdin to
+// all of them at once, demuxing their output with a per-node prefix.
+func (o *commandOptions) runFanoutShell(nodes []string) error {
+	cfg, err := config.Load(o.configPath)
+	if err != nil {
+		return err
+	}
+
+	shells, err := o.openNodeShells(nodes)
+	if err != nil {
+		return err
+	}
+	if !o.keepPod {
+		defer closeAll(shells)
+	}
+
+	results := nodeshell.RunBroadcast(shells, cfg.ShellCommand, os.Stdin, os.Stdout, os.Stderr)
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("[%s] error: %v\n", result.Node, result.Err)
+		}
+	}
+
+	return nil
+}
+
+func (o *commandOptions) runExec(command []string) error {
+	err := o.initKubeClient()
+	if err != nil {
+		return err
+	}
+
+	nodes, err := o.resolveNodes()
+	if err != nil {
+		return err
+	}
+	if len(nodes) > 0 {
+		return o.runExecFanout(nodes, command)
+	}
+
+	err = o.ensureNode()
+	if err != nil {
+		return err
+	}
+
+	nodeShell, err := o.newNodeShell(o.nodeName)
+	if err != nil {
+		return err
+	}
+	if !o.keepPod {
+		defer nodeShell.RetryClose()
+	}
+
+	code, err := nodeShell.Exec(command)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return &exitCodeError{code: code}
+	}
+
+	return nil
+}
+
+// runExecFanout runs command on every node concurrently (bounded by
+// --parallel), then prints a summary of each node's exit code.
+func (o *commandOptions) runExecFanout(nodes []string, command []string) error {
+	shells, err := o.openNodeShells(nodes)
+	if err != nil {
+		return err
+	}
+	if !o.keepPod {
+		defer closeAll(shells)
+	}
+
+	results := nodeshell.RunFanout(shells, command, o.parallel, os.Stdout, os.Stderr)
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("[%s] error: %v\n", result.Node, result.Err)
+			failed++
+			continue
+		}
+
+		fmt.Printf("[%s] exit code: %d\n", result.Node, result.ExitCode)
+		if result.ExitCode != 0 {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return &exitCodeError{code: 1}
+	}
+
+	return nil
+}
+
+// resolveNodes returns the nodes matched by --all/--selector/--node. It
+// returns an empty slice when none of those flags were set, signaling that
+// the caller should fall back to the single positional <node> flow.
+func (o *commandOptions) resolveNodes() ([]string, error) {
+	if !o.allNodes && o.selector == "" && len(o.nodeNames) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	var nodes []string
+
+	if o.allNodes || o.selector != "" {
+		ctx := context.Background()
+		listOpts := metav1.ListOptions{LabelSelector: o.selector}
+		nodeList, err := o.kubeClient.CoreV1().Nodes().List(ctx, listOpts)
+		if err != nil {
+			return nil, fmt.Errorf("list nodes error: %w", err)
+		}
+
+		for _, item := range nodeList.Items {
+			if !seen[item.Name] {
+				seen[item.Name] = true
+				nodes = append(nodes, item.Name)
+			}
+		}
+	}
+
+	for _, name := range o.nodeNames {
+		if !seen[name] {
+			seen[name] = true
+			nodes = append(nodes, name)
+		}
+	}
+
+	if len(nodes) == 0 {
+		return nil, errors.New("no node matched --all/--selector/--node")
+	}
+
+	return nodes, nil
+}
+
+// openNodeShells opens a node shell on every node, bounded by --parallel so
+// a large --all/--selector fanout doesn't serialize pod creation/readiness
+// (each up to checkPodStatusTimeout) one node at a time.
+func (o *commandOptions) openNodeShells(nodes []string) (map[string]*nodeshell.NodeShell, error) {
+	parallel := o.parallel
+	if parallel <= 0 || parallel > len(nodes) {
+		parallel = len(nodes)
+	}
+
+	type shellResult struct {
+		node  string
+		shell *nodeshell.NodeShell
+		err   error
+	}
+
+	jobs := make(chan string, len(nodes))
+	for _, node := range nodes {
+		jobs <- node
+	}
+	close(jobs)
+
+	results := make(chan shellResult, len(nodes))
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for node := range jobs {
+				shell, err := o.newNodeShell(node)
+				results <- shellResult{node: node, shell: shell, err: err}
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	shells := make(map[string]*nodeshell.NodeShell, len(nodes))
+	var firstErr error
+	for r := range results {
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("open node-shell on %s error: %w", r.node, r.err)
+			}
+			continue
+		}
+		shells[r.node] = r.shell
+	}
+
+	if firstErr != nil {
+		closeAll(shells)
+		return nil, firstErr
+	}
+
+	return shells, nil
+}
+
+func closeAll(shells map[string]*nodeshell.NodeShell) {
+	for _, shell := range shells {
+		shell.RetryClose()
+	}
+}
+
+func (o *commandOptions) runForward(rawMappings []string) error {
+	mappings := make([]nodeshell.PortMapping, 0, len(rawMappings))
+	for _, raw := range rawMappings {
+		mapping, err := nodeshell.ParsePortMapping(raw)
+		if err != nil {
+			return err
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	nodeShell, err := o.openNodeShell()
+	if err != nil {
+		return err
+	}
+	if !o.keepPod {
+		defer nodeShell.RetryClose()
+	}
+
+	// Forward blocks per mapping until its listener fails, so wait for every
+	// mapping rather than returning on the first error: one bad mapping
+	// (e.g. a hostPort already in use locally) shouldn't tear down the
+	// node shell and every other, still-healthy mapping.
+	var wg sync.WaitGroup
+	for _, mapping := range mappings {
+		mapping := mapping
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := nodeShell.Forward(mapping); err != nil {
+				fmt.Printf("WARNING: forward %s error: %v\n", mapping, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return fmt.Errorf("all %d port mapping(s) failed", len(mappings))
+}
+
+func (o *commandOptions) runCp(src, dst string) error {
+	srcNode, srcPath, srcIsRemote := parseCpArg(src)
+	dstNode, dstPath, dstIsRemote := parseCpArg(dst)
+
+	if srcIsRemote == dstIsRemote {
+		return errors.New("exactly one of <src>/<dst> must be a remote node:path")
+	}
+
+	if srcIsRemote {
+		o.nodeName = srcNode
+	} else {
+		o.nodeName = dstNode
+	}
+
+	nodeShell, err := o.openNodeShell()
+	if err != nil {
+		return err
+	}
+	if !o.keepPod {
+		defer nodeShell.RetryClose()
+	}
+
+	if srcIsRemote {
+		return nodeShell.Download(srcPath, dstPath)
+	}
+	return nodeShell.Upload(srcPath, dstPath)
+}
+
+// parseCpArg splits a cp argument in the form "node:path" into its node and
+// path components. A non-remote argument (no colon, or a Windows-style drive
+// letter) is returned as-is with isRemote set to false.
+func parseCpArg(arg string) (node, path string, isRemote bool) {
+	idx := strings.Index(arg, ":")
+	if idx <= 0 {
+		return "", arg, false
+	}
+	if idx == 1 && isDriveLetter(arg[0]) {
+		return "", arg, false
+	}
+
+	return arg[:idx], arg[idx+1:], true
+}
+
+func isDriveLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func (o *commandOptions) openNodeShell() (*nodeshell.NodeShell, error) {
+	err := o.initKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.ensureNode()
+	if err != nil {
+		return nil, err
+	}
+
+	return o.newNodeShell(o.nodeName)
+}
+
+// newNodeShell builds a NodeShell for node, assuming o.kubeClient is already
+// initialized. Used directly (instead of openNodeShell) by the fanout paths,
+// which resolve many nodes up front instead of a single o.nodeName.
+func (o *commandOptions) newNodeShell(node string) (*nodeshell.NodeShell, error) {
+	cfg, err := config.Load(o.configPath)
+	if err != nil {
+		return nil, err
+	}
+	if o.namespace != "" {
+		cfg.PodNamespace = o.namespace
+	}
+
+	podOverlay, err := o.loadPodOverlay()
+	if err != nil {
+		return nil, err
+	}
+
+	recordPath := o.recordPath
+	if recordPath == "" {
+		recordPath = cfg.Recording
+	}
+
+	return nodeshell.New(node, cfg, o.kubeConfig, o.kubeClient, podOverlay, recordPath)
+}
+
+func (o *commandOptions) loadPodOverlay() (*v1.Pod, error) {
+	if o.podTemplateFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(o.podTemplateFile)
+	if err != nil {
+		return nil, fmt.Errorf("read pod template file: %w", err)
+	}
+
+	var pod v1.Pod
+	err = yaml.Unmarshal(data, &pod)
+	if err != nil {
+		return nil, fmt.Errorf("parse pod template file: %w", err)
+	}
+
+	return &pod, nil
+}
+
 func (o *commandOptions) ensureNode() error {
 	if o.nodeName != "" {
 		return nil
@@ -163,10 +669,37 @@ func (o *commandOptions) initKubeClient() error {
 	rules := clientcmd.NewDefaultClientConfigLoadingRules()
 	rules.ExplicitPath = o.kubeConfigPath
 
-	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, nil).ClientConfig()
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: o.kubeContext,
+		Context: clientcmdapi.Context{
+			Cluster:   o.kubeCluster,
+			AuthInfo:  o.kubeUser,
+			Namespace: o.namespace,
+		},
+	}
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+
+	resolvedNamespace := o.namespace
+	cfg, err := loader.ClientConfig()
 	if err != nil {
-		return fmt.Errorf("read kube config error: %w", err)
+		if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+			return fmt.Errorf("read kube config error: %w", err)
+		}
+
+		cfg, err = rest.InClusterConfig()
+		if err != nil {
+			return fmt.Errorf("read in-cluster config error: %w", err)
+		}
+		if resolvedNamespace == "" {
+			resolvedNamespace = inClusterNamespace()
+		}
+	} else if resolvedNamespace == "" {
+		ns, _, nsErr := loader.Namespace()
+		if nsErr == nil {
+			resolvedNamespace = ns
+		}
 	}
+
 	if o.Insecure {
 		cfg.Insecure = true
 	}
@@ -178,5 +711,29 @@ func (o *commandOptions) initKubeClient() error {
 
 	o.kubeConfig = cfg
 	o.kubeClient = client
+	o.resolvedNamespace = resolvedNamespace
+
+	if o.verbose {
+		rawConfig, _ := loader.RawConfig()
+		context := o.kubeContext
+		if context == "" {
+			context = rawConfig.CurrentContext
+		}
+		fmt.Printf("using context %q, namespace %q\n", context, o.resolvedNamespace)
+	}
+
 	return nil
 }
+
+// inClusterNamespace reads the namespace a pod's service account is bound
+// to, used as the namespace reported by --verbose when running with no
+// kubeconfig and no --namespace. It does not change where the node-shell
+// pod is created.
+func inClusterNamespace() string {
+	data, err := os.ReadFile(inClusterNamespaceFile)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
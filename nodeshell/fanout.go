@@ -0,0 +1,213 @@
+package nodeshell
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// ansiColors cycles through a small palette so each node in a fanout gets a
+// visually distinct output prefix.
+var ansiColors = []string{"\x1b[36m", "\x1b[35m", "\x1b[33m", "\x1b[32m", "\x1b[34m", "\x1b[31m"}
+
+const ansiReset = "\x1b[0m"
+
+// FanoutResult is one node's outcome from RunFanout or RunBroadcast.
+type FanoutResult struct {
+	Node     string
+	ExitCode int
+	Err      error
+}
+
+// RunFanout runs command on every node shell concurrently, bounded by
+// parallel (0 means unbounded), prefixing each line of output with the node
+// name, and returns one FanoutResult per node sorted by node name.
+func RunFanout(shells map[string]*NodeShell, command []string, parallel int, stdout, stderr io.Writer) []FanoutResult {
+	nodes := sortedNodes(shells)
+	if parallel <= 0 || parallel > len(nodes) {
+		parallel = len(nodes)
+	}
+
+	jobs := make(chan string, len(nodes))
+	for _, node := range nodes {
+		jobs <- node
+	}
+	close(jobs)
+
+	var stdoutMu, stderrMu sync.Mutex
+	results := make(chan FanoutResult, len(nodes))
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for node := range jobs {
+				out := newPrefixWriter(stdout, &stdoutMu, node)
+				errOut := newPrefixWriter(stderr, &stderrMu, node)
+				code, err := shells[node].ExecStream(command, nil, out, errOut)
+				results <- FanoutResult{Node: node, ExitCode: code, Err: err}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(results)
+
+	return collectResults(results)
+}
+
+// RunBroadcast runs command on every node shell, duplicating every chunk
+// read from stdin to each node's exec stream, and demuxing output with a
+// per-node prefix. It returns once every node's exec stream has ended.
+func RunBroadcast(shells map[string]*NodeShell, command []string, stdin io.Reader, stdout, stderr io.Writer) []FanoutResult {
+	nodes := sortedNodes(shells)
+
+	// Each node gets its own buffered channel feeding a dedicated forwarder
+	// goroutine, rather than one shared loop writing to every node's pipe in
+	// turn. io.Pipe writes block until the remote side reads, so a node
+	// whose exec stream stopped reading (remote error, network drop, shell
+	// exit) must not be able to stall stdin delivery to the other, healthy
+	// nodes.
+	chans := make(map[string]chan []byte, len(nodes))
+	results := make(chan FanoutResult, len(nodes))
+	var stdoutMu, stderrMu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, node := range nodes {
+		pr, pw := io.Pipe()
+		ch := make(chan []byte, 32)
+		chans[node] = ch
+
+		wg.Add(1)
+		go func(node string, pr *io.PipeReader, pw *io.PipeWriter) {
+			defer wg.Done()
+			out := newPrefixWriter(stdout, &stdoutMu, node)
+			errOut := newPrefixWriter(stderr, &stderrMu, node)
+			code, err := shells[node].ExecStream(command, pr, out, errOut)
+			// Unblock the forwarder below if it's currently stuck writing
+			// to a pipe whose reader just went away.
+			pw.Close()
+			results <- FanoutResult{Node: node, ExitCode: code, Err: err}
+		}(node, pr, pw)
+
+		go func(ch chan []byte, pw *io.PipeWriter) {
+			for chunk := range ch {
+				if _, err := pw.Write(chunk); err != nil {
+					return
+				}
+			}
+			pw.Close()
+		}(ch, pw)
+	}
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := stdin.Read(buf)
+			if n > 0 {
+				chunk := append([]byte(nil), buf[:n]...)
+				for _, ch := range chans {
+					select {
+					case ch <- chunk:
+					default:
+						// This node's forwarder is blocked or gone; drop
+						// the chunk for it instead of blocking every other
+						// node's stdin delivery.
+					}
+				}
+			}
+			if err != nil {
+				for _, ch := range chans {
+					close(ch)
+				}
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(results)
+
+	return collectResults(results)
+}
+
+func sortedNodes(shells map[string]*NodeShell) []string {
+	nodes := make([]string, 0, len(shells))
+	for node := range shells {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func collectResults(results <-chan FanoutResult) []FanoutResult {
+	out := make([]FanoutResult, 0, len(results))
+	for r := range results {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Node < out[j].Node })
+	return out
+}
+
+// prefixWriter tees writes to out, prepending a colored "[node] " prefix at
+// the start of every line so fanout output from multiple nodes stays
+// distinguishable when interleaved. mu must be shared by every prefixWriter
+// wrapping the same out, since one Write call here can issue several
+// underlying writes (prefix, then per-line chunks) that must not interleave
+// with another node's concurrent write to the same out.
+type prefixWriter struct {
+	out    io.Writer
+	mu     *sync.Mutex
+	prefix string
+	atBOL  bool
+}
+
+func newPrefixWriter(out io.Writer, mu *sync.Mutex, node string) *prefixWriter {
+	color := ansiColors[nodeColorIndex(node)]
+	return &prefixWriter{
+		out:    out,
+		mu:     mu,
+		prefix: fmt.Sprintf("%s[%s]%s ", color, node, ansiReset),
+		atBOL:  true,
+	}
+}
+
+func nodeColorIndex(node string) int {
+	var sum int
+	for _, r := range node {
+		sum += int(r)
+	}
+	return sum % len(ansiColors)
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	total := len(p)
+	for len(p) > 0 {
+		if w.atBOL {
+			if _, err := io.WriteString(w.out, w.prefix); err != nil {
+				return total - len(p), err
+			}
+			w.atBOL = false
+		}
+
+		idx := bytes.IndexByte(p, '\n')
+		if idx < 0 {
+			if _, err := w.out.Write(p); err != nil {
+				return total - len(p), err
+			}
+			return total, nil
+		}
+
+		if _, err := w.out.Write(p[:idx+1]); err != nil {
+			return total - len(p), err
+		}
+		p = p[idx+1:]
+		w.atBOL = true
+	}
+	return total, nil
+}
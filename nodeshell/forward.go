@@ -0,0 +1,86 @@
+package nodeshell
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PortMapping describes a local port that should be relayed to a
+// node-reachable address through the node-shell pod, e.g. "8080:localhost:80".
+type PortMapping struct {
+	HostPort   int
+	TargetHost string
+	TargetPort int
+}
+
+// ParsePortMapping parses a "hostPort:targetHost:targetPort" mapping.
+func ParsePortMapping(raw string) (PortMapping, error) {
+	parts := strings.SplitN(raw, ":", 3)
+	if len(parts) != 3 {
+		return PortMapping{}, fmt.Errorf("invalid port mapping %q, expect hostPort:targetHost:targetPort", raw)
+	}
+
+	hostPort, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return PortMapping{}, fmt.Errorf("invalid host port %q in mapping %q", parts[0], raw)
+	}
+
+	targetPort, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return PortMapping{}, fmt.Errorf("invalid target port %q in mapping %q", parts[2], raw)
+	}
+
+	return PortMapping{HostPort: hostPort, TargetHost: parts[1], TargetPort: targetPort}, nil
+}
+
+func (m PortMapping) String() string {
+	return fmt.Sprintf("%d:%s:%d", m.HostPort, m.TargetHost, m.TargetPort)
+}
+
+// Forward listens on mapping.HostPort and, for every accepted connection,
+// relays it to mapping.TargetHost:TargetPort through a socat (or nc, if
+// socat isn't installed in the image) process exec'd inside the node-shell
+// pod. Because the pod runs with HostNetwork, this reaches any port on the
+// node's network namespace. Forward blocks until the listener fails.
+//
+// This deliberately exec's a relay per connection rather than going through
+// k8s.io/client-go/tools/portforward: that package forwards a local port to
+// a port the pod's own container is already listening on, but the
+// node-shell pod has nothing bound to arbitrary node-side ports like
+// TargetPort — the relay has to dial out to TargetHost:TargetPort itself.
+// Each accepted connection opens its own exec stream through execStream (the
+// same SPDY exec path Exec and Upload/Download use) running socat/nc,
+// instead of a PortForward stream that would still need a matching listener
+// inside the pod to forward to.
+func (n *NodeShell) Forward(mapping PortMapping) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", mapping.HostPort))
+	if err != nil {
+		return fmt.Errorf("listen on port %d error: %w", mapping.HostPort, err)
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("accept connection for %s error: %w", mapping, err)
+		}
+
+		go n.relayConn(conn, mapping)
+	}
+}
+
+func (n *NodeShell) relayConn(conn net.Conn, mapping PortMapping) {
+	defer conn.Close()
+
+	relay := fmt.Sprintf("socat - TCP:%s:%d 2>/dev/null || nc %s %d",
+		mapping.TargetHost, mapping.TargetPort, mapping.TargetHost, mapping.TargetPort)
+	command := []string{"sh", "-c", relay}
+
+	err := n.execStream(command, conn, conn, os.Stderr)
+	if err != nil {
+		fmt.Printf("WARNING: forward %s error: %v\n", mapping, err)
+	}
+}
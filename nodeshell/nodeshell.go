@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
@@ -12,10 +13,12 @@ import (
 	v1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
 	"k8s.io/kubectl/pkg/util/term"
 	"k8s.io/utils/ptr"
 )
@@ -23,8 +26,7 @@ import (
 const (
 	containerName = "node-shell"
 
-	checkPodStatusInterval = time.Second
-	checkPodStatusTimeout  = time.Minute
+	checkPodStatusTimeout = time.Minute
 
 	closeMaxRetry  = 5
 	closeRetryTime = time.Second * 3
@@ -37,16 +39,26 @@ type NodeShell struct {
 
 	config *config.Config
 
+	// podOverlay, when set, is applied on top of the built pod spec as an
+	// escape hatch for overrides the config's PodTemplate doesn't cover.
+	podOverlay *v1.Pod
+
+	// recordPath, when set, makes Run() capture the session to an asciicast
+	// v2 file at this path.
+	recordPath string
+
 	kubeConfig *rest.Config
 	kubeClient *kubernetes.Clientset
 }
 
-func New(node string, config *config.Config, kubeConfig *rest.Config, kubeClient *kubernetes.Clientset) (*NodeShell, error) {
+func New(node string, config *config.Config, kubeConfig *rest.Config, kubeClient *kubernetes.Clientset, podOverlay *v1.Pod, recordPath string) (*NodeShell, error) {
 	podName := strings.ReplaceAll(config.PodName, "{node}", node)
 	ns := &NodeShell{
 		node:       node,
 		podName:    podName,
 		config:     config,
+		podOverlay: podOverlay,
+		recordPath: recordPath,
 		kubeConfig: kubeConfig,
 		kubeClient: kubeClient,
 	}
@@ -79,46 +91,94 @@ func (n *NodeShell) start() error {
 	// Check if the node shell pod exists, and create it if it doesn't exist
 	pod, err := n.kubeClient.CoreV1().Pods(n.config.PodNamespace).Get(ctx, n.podName, metav1.GetOptions{})
 	if err == nil {
-		// If the pod exists and is already in the "Running" state, consider the node shell to be ready
-		if pod.Status.Phase == v1.PodRunning {
+		// If the pod exists and is already ready, consider the node shell to be ready
+		if podReady(pod) {
 			return nil
 		}
 	} else {
 		if !kerrors.IsNotFound(err) {
 			return fmt.Errorf("get node-shell pod error: %w", err)
 		}
-		pod = n.buildPod()
-		_, err = n.kubeClient.CoreV1().Pods(n.config.PodNamespace).Create(ctx, pod, metav1.CreateOptions{})
+		pod, err = n.kubeClient.CoreV1().Pods(n.config.PodNamespace).Create(ctx, n.buildPod(), metav1.CreateOptions{})
 		if err != nil {
 			return fmt.Errorf("create node-shell pod error: %w", err)
 		}
 	}
 
-	// Wait for the pod to start up, and wait for no more than the timeout period
-	checkStatusTk := time.NewTicker(checkPodStatusInterval)
-	checkStatusTimeout := time.NewTimer(checkPodStatusTimeout)
+	return n.waitRunning(ctx, pod.ResourceVersion)
+}
+
+// waitRunning watches the node-shell pod starting from resourceVersion until
+// it becomes ready, fails fast on a container in a known bad waiting state,
+// or checkPodStatusTimeout elapses.
+func (n *NodeShell) waitRunning(ctx context.Context, resourceVersion string) error {
+	watchCtx, cancel := context.WithTimeout(ctx, checkPodStatusTimeout)
+	defer cancel()
+
+	selector := fields.OneTermEqualSelector("metadata.name", n.podName).String()
+	watcher, err := n.kubeClient.CoreV1().Pods(n.config.PodNamespace).Watch(watchCtx, metav1.ListOptions{
+		FieldSelector:   selector,
+		ResourceVersion: resourceVersion,
+	})
+	if err != nil {
+		return fmt.Errorf("watch node-shell pod error: %w", err)
+	}
+	defer watcher.Stop()
 
 	for {
 		select {
-		case <-checkStatusTk.C:
-			pod, err := n.kubeClient.CoreV1().Pods(n.config.PodNamespace).Get(ctx, n.podName, metav1.GetOptions{})
-			if err != nil {
-				if !kerrors.IsNotFound(err) {
-					return fmt.Errorf("get node-shell pod error: %v", err)
-				}
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return errors.New("watch node-shell pod closed unexpectedly")
+			}
+
+			pod, ok := event.Object.(*v1.Pod)
+			if !ok {
 				continue
 			}
 
-			if pod.Status.Phase == v1.PodRunning {
+			if reason, message, failed := podFailureReason(pod); failed {
+				return fmt.Errorf("node-shell pod failed to start: %s: %s", reason, message)
+			}
+			if podReady(pod) {
 				return nil
 			}
 
-		case <-checkStatusTimeout.C:
+		case <-watchCtx.Done():
 			return errors.New("timeout to wait node-shell pod to running")
 		}
 	}
 }
 
+// podReady reports whether the node-shell container is up and ready.
+func podReady(pod *v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning {
+		return false
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == containerName {
+			return cs.Ready
+		}
+	}
+	return false
+}
+
+// podFailureReason extracts the reason/message of a container stuck in a
+// waiting state that will never resolve on its own, e.g. a bad image
+// reference or an invalid container config.
+func podFailureReason(pod *v1.Pod) (reason, message string, failed bool) {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Waiting == nil {
+			continue
+		}
+		switch cs.State.Waiting.Reason {
+		case "ImagePullBackOff", "ErrImagePull", "CreateContainerConfigError", "CreateContainerError":
+			return cs.State.Waiting.Reason, cs.State.Waiting.Message, true
+		}
+	}
+	return "", "", false
+}
+
 func (n *NodeShell) Run() error {
 	t := term.TTY{
 		In:  os.Stdin,
@@ -148,9 +208,22 @@ func (n *NodeShell) Run() error {
 		return fmt.Errorf("create executor error: %w", err)
 	}
 
+	out := t.Out
+	if n.recordPath != "" {
+		size := t.GetSize()
+		rec, err := newRecordingWriter(n.recordPath, t.Out, int(size.Width), int(size.Height))
+		if err != nil {
+			return fmt.Errorf("start recording error: %w", err)
+		}
+		defer rec.Close()
+
+		out = rec
+		sizeQueue = &recordingSizeQueue{inner: sizeQueue, rec: rec}
+	}
+
 	streamOpts := remotecommand.StreamOptions{
 		Stdin:             t.In,
-		Stdout:            t.Out,
+		Stdout:            out,
 		Stderr:            nil,
 		Tty:               true,
 		TerminalSizeQueue: sizeQueue,
@@ -166,10 +239,106 @@ func (n *NodeShell) Run() error {
 	})
 }
 
+// Exec runs command inside the node-shell pod without a TTY, streaming stdout
+// and stderr to the current process separately, and returns the remote exit
+// code instead of treating a non-zero exit as a Go error.
+func (n *NodeShell) Exec(command []string) (int, error) {
+	return n.ExecStream(command, os.Stdin, os.Stdout, os.Stderr)
+}
+
+// ExecStream is like Exec but lets the caller supply stdin/stdout/stderr,
+// so a single command can be run against many node shells with per-node
+// output streams (see RunFanout/RunBroadcast).
+func (n *NodeShell) ExecStream(command []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	err := n.execStream(command, stdin, stdout, stderr)
+	if err == nil {
+		return 0, nil
+	}
+
+	var codeErr utilexec.CodeExitError
+	if errors.As(err, &codeErr) {
+		return codeErr.ExitStatus(), nil
+	}
+
+	return 0, fmt.Errorf("exec command error: %w", err)
+}
+
+// Upload copies localPath into the node's filesystem at remotePath by piping
+// a tar stream of localPath into `tar xf -` running inside the node-shell pod.
+func (n *NodeShell) Upload(localPath, remotePath string) error {
+	dir, base := splitRemotePath(remotePath)
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeTar(w, localPath))
+	}()
+
+	command := []string{"tar", "xf", "-", "-C", dir, "--transform", tarTransformPattern(base)}
+	return n.execStream(command, r, os.Stdout, os.Stderr)
+}
+
+// Download copies remotePath out of the node's filesystem by running
+// `tar cf -` inside the node-shell pod and extracting the resulting stream
+// into localPath.
+func (n *NodeShell) Download(remotePath, localPath string) error {
+	dir, base := splitRemotePath(remotePath)
+	r, w := io.Pipe()
+
+	errCh := make(chan error, 1)
+	go func() {
+		extractErr := extractTar(r, localPath)
+		// If extraction stopped early (a rejected path-traversal entry, a
+		// local write failure, ...), unblock execStream below, which would
+		// otherwise block forever writing the remote tar stream into a pipe
+		// nothing is draining anymore.
+		r.CloseWithError(extractErr)
+		errCh <- extractErr
+	}()
+
+	command := []string{"tar", "cf", "-", "-C", dir, base}
+	err := n.execStream(command, nil, w, os.Stderr)
+	w.Close()
+
+	if extractErr := <-errCh; extractErr != nil {
+		return fmt.Errorf("extract tar stream error: %w", extractErr)
+	}
+	if err != nil {
+		return fmt.Errorf("run remote tar error: %w", err)
+	}
+
+	return nil
+}
+
+// execStream runs command inside the node-shell container without a TTY,
+// reusing the same SPDY executor plumbing as Run().
+func (n *NodeShell) execStream(command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	req := n.kubeClient.CoreV1().RESTClient().Post().Resource("pods").Name(n.podName).Namespace(n.config.PodNamespace).SubResource("exec")
+	opts := &v1.PodExecOptions{
+		Container: containerName,
+		Command:   command,
+		Stdin:     stdin != nil,
+		Stdout:    stdout != nil,
+		Stderr:    stderr != nil,
+	}
+	req.VersionedParams(opts, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(n.kubeConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("create executor error: %w", err)
+	}
+
+	return exec.StreamWithContext(context.Background(), remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+}
+
 func (n *NodeShell) buildPod() *v1.Pod {
 	args := []string{"-t", "1", "-m", "-u", "-i", "-n"}
 	args = append(args, n.config.PauseCommand...)
-	return &v1.Pod{
+
+	tpl := n.config.PodTemplate
+	pod := &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      n.podName,
 			Namespace: n.config.PodNamespace,
@@ -183,12 +352,29 @@ func (n *NodeShell) buildPod() *v1.Pod {
 			HostNetwork: true,
 			HostPID:     true,
 			HostIPC:     true,
+			Tolerations: tpl.Tolerations,
+
+			NodeSelector:     tpl.NodeSelector,
+			ImagePullSecrets: tpl.ImagePullSecrets,
+			HostAliases:      tpl.HostAliases,
+
+			ServiceAccountName: tpl.ServiceAccountName,
+			PriorityClassName:  tpl.PriorityClassName,
+
+			Volumes: tpl.ExtraVolumes,
+
 			Containers: []v1.Container{
 				{
 					Name:    containerName,
 					Image:   n.config.Image,
 					Command: []string{"nsenter"},
 					Args:    args,
+					Env:     tpl.Env,
+					Resources: v1.ResourceRequirements{
+						Limits:   tpl.Resources.Limits,
+						Requests: tpl.Resources.Requests,
+					},
+					VolumeMounts: tpl.ExtraVolumeMounts,
 					SecurityContext: &v1.SecurityContext{
 						Privileged: ptr.To(true),
 					},
@@ -196,6 +382,73 @@ func (n *NodeShell) buildPod() *v1.Pod {
 			},
 		},
 	}
+
+	mergePodOverlay(pod, n.podOverlay)
+	return pod
+}
+
+// mergePodOverlay applies overlay on top of pod as a last escape-hatch
+// override: scalar fields replace the base value when set, map fields are
+// merged key-by-key, and slice fields are appended.
+func mergePodOverlay(pod *v1.Pod, overlay *v1.Pod) {
+	if overlay == nil {
+		return
+	}
+
+	for k, v := range overlay.Labels {
+		pod.Labels[k] = v
+	}
+	if len(overlay.Annotations) > 0 {
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		for k, v := range overlay.Annotations {
+			pod.Annotations[k] = v
+		}
+	}
+
+	pod.Spec.Tolerations = append(pod.Spec.Tolerations, overlay.Spec.Tolerations...)
+	pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, overlay.Spec.ImagePullSecrets...)
+	pod.Spec.HostAliases = append(pod.Spec.HostAliases, overlay.Spec.HostAliases...)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, overlay.Spec.Volumes...)
+
+	if len(overlay.Spec.NodeSelector) > 0 {
+		if pod.Spec.NodeSelector == nil {
+			pod.Spec.NodeSelector = map[string]string{}
+		}
+		for k, v := range overlay.Spec.NodeSelector {
+			pod.Spec.NodeSelector[k] = v
+		}
+	}
+	if overlay.Spec.ServiceAccountName != "" {
+		pod.Spec.ServiceAccountName = overlay.Spec.ServiceAccountName
+	}
+	if overlay.Spec.PriorityClassName != "" {
+		pod.Spec.PriorityClassName = overlay.Spec.PriorityClassName
+	}
+
+	if len(overlay.Spec.Containers) == 0 {
+		return
+	}
+	oc := overlay.Spec.Containers[0]
+	c := &pod.Spec.Containers[0]
+	if oc.Image != "" {
+		c.Image = oc.Image
+	}
+	c.Env = append(c.Env, oc.Env...)
+	c.VolumeMounts = append(c.VolumeMounts, oc.VolumeMounts...)
+	for res, qty := range oc.Resources.Limits {
+		if c.Resources.Limits == nil {
+			c.Resources.Limits = v1.ResourceList{}
+		}
+		c.Resources.Limits[res] = qty
+	}
+	for res, qty := range oc.Resources.Requests {
+		if c.Resources.Requests == nil {
+			c.Resources.Requests = v1.ResourceList{}
+		}
+		c.Resources.Requests[res] = qty
+	}
 }
 
 func (n *NodeShell) Close() error {
@@ -0,0 +1,106 @@
+package nodeshell
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// asciicastHeader is the first line of an asciicast v2 file.
+// See https://docs.asciinema.org/manual/asciicast/v2/
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env"`
+}
+
+// recordingWriter tees terminal output to both the real terminal and an
+// asciicast v2 file, one JSON event per line, flushed immediately so an
+// aborted session still leaves a valid recording.
+type recordingWriter struct {
+	out   io.Writer
+	file  *os.File
+	start time.Time
+}
+
+func newRecordingWriter(path string, out io.Writer, width, height int) (*recordingWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create recording file error: %w", err)
+	}
+
+	rw := &recordingWriter{out: out, file: file, start: time.Now()}
+	header := asciicastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: rw.start.Unix(),
+		Env: map[string]string{
+			"SHELL": os.Getenv("SHELL"),
+			"TERM":  os.Getenv("TERM"),
+		},
+	}
+	err = rw.writeEvent(header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return rw, nil
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	n, err := rw.out.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	err = rw.writeEvent([]any{time.Since(rw.start).Seconds(), "o", string(p)})
+	if err != nil {
+		return n, fmt.Errorf("write recording event error: %w", err)
+	}
+
+	return n, nil
+}
+
+// Resize emits a size-change event, in the "COLSxROWS" form asciicast v2 uses.
+func (rw *recordingWriter) Resize(cols, rows uint16) error {
+	size := fmt.Sprintf("%dx%d", cols, rows)
+	return rw.writeEvent([]any{time.Since(rw.start).Seconds(), "r", size})
+}
+
+func (rw *recordingWriter) writeEvent(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	data = append(data, '\n')
+	_, err = rw.file.Write(data)
+	return err
+}
+
+func (rw *recordingWriter) Close() error {
+	return rw.file.Close()
+}
+
+// recordingSizeQueue wraps a remotecommand.TerminalSizeQueue so every size
+// change it produces is also recorded as an asciicast "r" event.
+type recordingSizeQueue struct {
+	inner remotecommand.TerminalSizeQueue
+	rec   *recordingWriter
+}
+
+func (q *recordingSizeQueue) Next() *remotecommand.TerminalSize {
+	size := q.inner.Next()
+	if size != nil {
+		q.rec.Resize(size.Width, size.Height)
+	}
+	return size
+}
@@ -0,0 +1,144 @@
+package nodeshell
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tarTransformPattern rewrites only the top-level path component of every
+// extracted entry to base, keeping nested entries (e.g. "olddir/sub/file")
+// relative to it intact. Rewriting the whole name (e.g. "s|^.*|base|")
+// collapses every entry in a directory archive onto a single path.
+func tarTransformPattern(base string) string {
+	return fmt.Sprintf("s|^[^/]*|%s|", escapeTarReplacement(base))
+}
+
+// escapeTarReplacement escapes characters GNU tar's --transform treats
+// specially in the replacement half of a sed-style s|||  expression.
+func escapeTarReplacement(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `&`, `\&`, `|`, `\|`)
+	return r.Replace(s)
+}
+
+// splitRemotePath splits a remote path into the directory to pass to `tar -C`
+// and the base name tar should operate on, so that uploads/downloads don't
+// leak the full path into the archive.
+func splitRemotePath(path string) (dir, base string) {
+	dir = filepath.Dir(path)
+	base = filepath.Base(path)
+	if dir == "" {
+		dir = "."
+	}
+	return dir, base
+}
+
+// writeTar archives localPath (a file or directory) into w.
+func writeTar(w io.Writer, localPath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("stat local path error: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	base := filepath.Base(localPath)
+	if !info.IsDir() {
+		return writeTarFile(tw, localPath, base, info)
+	}
+
+	return filepath.Walk(localPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(localPath, path)
+		if err != nil {
+			return err
+		}
+		name := base
+		if rel != "." {
+			name = filepath.Join(base, rel)
+		}
+
+		if info.IsDir() {
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			hdr.Name = name + "/"
+			return tw.WriteHeader(hdr)
+		}
+
+		return writeTarFile(tw, path, name, info)
+	})
+}
+
+func writeTarFile(tw *tar.Writer, path, name string, info os.FileInfo) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open local file error: %w", err)
+	}
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// extractTar reads a tar stream from r and extracts it under localPath.
+func extractTar(r io.Reader, localPath string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar stream error: %w", err)
+		}
+
+		name := filepath.Clean(hdr.Name)
+		if strings.HasPrefix(name, "..") {
+			return fmt.Errorf("tar entry %q escapes destination", hdr.Name)
+		}
+
+		target := localPath
+		if hdr.Typeflag == tar.TypeDir || strings.Contains(name, string(filepath.Separator)) {
+			target = filepath.Join(localPath, name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode))
+			if err != nil {
+				return fmt.Errorf("create local file error: %w", err)
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}